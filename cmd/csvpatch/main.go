@@ -0,0 +1,44 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+// Command csvpatch applies a binary patch produced by "csvdiff -f=3" to
+// file A, reconstructing file B.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/riomhaire/csvdiff/csvdiff"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s PATCH FILEA\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		log.Fatalf("Expected PATCH and FILEA arguments\n")
+	}
+
+	patch, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Error while opening patch: '%s' (%s)\n", flag.Arg(0), err)
+	}
+	defer patch.Close()
+
+	a, err := os.Open(flag.Arg(1))
+	if err != nil {
+		log.Fatalf("Error while opening file: '%s' (%s)\n", flag.Arg(1), err)
+	}
+	defer a.Close()
+
+	if err := csvdiff.ApplyPatch(patch, a, os.Stdout); err != nil {
+		log.Fatalf("%s\n", err)
+	}
+}
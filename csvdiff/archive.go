@@ -0,0 +1,118 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+package csvdiff
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var zipMagic = []byte{'P', 'K', 0x03, 0x04}
+
+// splitMember splits "archive.tar.gz#reports/2024.csv" into the archive
+// path and the member name; filepath is returned unchanged when it carries
+// no '#'.
+func splitMember(filepath string) (archivePath, member string, hasMember bool) {
+	if i := strings.IndexByte(filepath, '#'); i >= 0 {
+		return filepath[:i], filepath[i+1:], true
+	}
+	return filepath, "", false
+}
+
+// memberFile pairs a reader over a single archive member with whatever
+// needs closing once the caller is done with it.
+type memberFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m *memberFile) Close() (err error) {
+	for _, c := range m.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+// openArchiveMember opens member inside the tar, tar.gz or zip archive at
+// archivePath, streaming it without extracting the rest of the archive.
+// When archivePath is "-", standard input is read as tar or tar.gz; zip
+// archives are not detected there.
+func openArchiveMember(archivePath, member string, c *Config) (io.ReadCloser, error) {
+	if archivePath == "-" {
+		return openTarMember(func() (io.ReadCloser, error) { return openRaw(archivePath, c) }, archivePath, member)
+	}
+	file, err := c.Fs.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("error while opening file: '%s' (%s)", archivePath, err)
+	}
+	var magic [4]byte
+	if _, err := file.ReadAt(magic[:], 0); err != nil && err != io.EOF {
+		file.Close()
+		return nil, fmt.Errorf("error while opening file: '%s' (%s)", archivePath, err)
+	}
+	if bytes.Equal(magic[:], zipMagic) {
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error while opening file: '%s' (%s)", archivePath, err)
+		}
+		return openZipMember(file, info.Size(), archivePath, member)
+	}
+	file.Close()
+	return openTarMember(func() (io.ReadCloser, error) { return openRaw(archivePath, c) }, archivePath, member)
+}
+
+func openTarMember(open func() (io.ReadCloser, error), archivePath, member string) (io.ReadCloser, error) {
+	raw, err := open()
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(raw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			raw.Close()
+			return nil, fmt.Errorf("%s: member '%s' not found", archivePath, member)
+		}
+		if err != nil {
+			raw.Close()
+			return nil, fmt.Errorf("error while reading archive '%s': %s", archivePath, err)
+		}
+		if hdr.Name == member {
+			return &memberFile{Reader: tr, closers: []io.Closer{raw}}, nil
+		}
+	}
+}
+
+func openZipMember(file io.Closer, size int64, archivePath, member string) (io.ReadCloser, error) {
+	readerAt, ok := file.(io.ReaderAt)
+	if !ok {
+		file.Close()
+		return nil, fmt.Errorf("%s: filesystem does not support random access required for zip archives", archivePath)
+	}
+	zr, err := zip.NewReader(readerAt, size)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error while reading archive '%s': %s", archivePath, err)
+	}
+	for _, zf := range zr.File {
+		if zf.Name == member {
+			rc, err := zf.Open()
+			if err != nil {
+				file.Close()
+				return nil, fmt.Errorf("error while reading archive '%s': %s", archivePath, err)
+			}
+			return &memberFile{Reader: rc, closers: []io.Closer{rc, file}}, nil
+		}
+	}
+	file.Close()
+	return nil, fmt.Errorf("%s: member '%s' not found", archivePath, member)
+}
@@ -0,0 +1,115 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+package csvdiff
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func writeTarArchive(t *testing.T, fs afero.Fs, path string, members map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range members {
+		hdr := &tar.Header{Name: name, Size: int64(len(content)), Mode: 0644}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("write tar header: %s", err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("write tar content: %s", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar writer: %s", err)
+	}
+	if err := afero.WriteFile(fs, path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("write %s: %s", path, err)
+	}
+}
+
+func writeZipArchive(t *testing.T, fs afero.Fs, path string, members map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range members {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("create zip member: %s", err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("write zip content: %s", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip writer: %s", err)
+	}
+	if err := afero.WriteFile(fs, path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("write %s: %s", path, err)
+	}
+}
+
+func TestOpenArchiveMemberTar(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writeTarArchive(t, fs, "data.tar", map[string]string{
+		"2023.csv": "id,name\n1,alice\n",
+		"2024.csv": "id,name\n2,bob\n",
+	})
+	config := NewConfig(',')
+	config.Fs = fs
+
+	rc, err := Open("data.tar#2024.csv", config)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer rc.Close()
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	if string(got) != "id,name\n2,bob\n" {
+		t.Errorf("got %q, want the 2024.csv member", got)
+	}
+}
+
+func TestOpenArchiveMemberZip(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writeZipArchive(t, fs, "data.zip", map[string]string{
+		"2023.csv": "id,name\n1,alice\n",
+		"2024.csv": "id,name\n2,bob\n",
+	})
+	config := NewConfig(',')
+	config.Fs = fs
+
+	rc, err := Open("data.zip#2023.csv", config)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer rc.Close()
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	if string(got) != "id,name\n1,alice\n" {
+		t.Errorf("got %q, want the 2023.csv member", got)
+	}
+}
+
+func TestOpenArchiveMemberNotFound(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writeTarArchive(t, fs, "data.tar", map[string]string{"2023.csv": "id,name\n1,alice\n"})
+	config := NewConfig(',')
+	config.Fs = fs
+
+	_, err := Open("data.tar#missing.csv", config)
+	if err == nil {
+		t.Fatal("expected an error for a missing member, got nil")
+	}
+}
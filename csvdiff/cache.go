@@ -0,0 +1,86 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+package csvdiff
+
+import (
+	"bytes"
+	"sort"
+)
+
+type Keys []uint64
+type Row [][]byte
+
+// bucketEntry is one row held in a Cache bucket, alongside the canonical
+// key bytes used to resolve hash collisions and the seq it was inserted
+// at, so leftover entries can later be replayed in encounter order.
+type bucketEntry struct {
+	key []byte
+	row Row
+	seq int
+}
+
+// Cache buffers the unmatched rows of one input, keyed by RowHash. A
+// RowHash can be shared by rows with different keys (a hash collision), so
+// each bucket is searched by raw key equality rather than trusted blindly.
+type Cache map[RowHash][]bucketEntry
+
+// searchCache looks up row by its hash and canonical key, removing it from
+// the bucket on a hit.
+func searchCache(cache Cache, hash RowHash, key []byte) (row Row, found bool) {
+	bucket := cache[hash]
+	for i, entry := range bucket {
+		if bytes.Equal(entry.key, key) {
+			row = entry.row
+			found = true
+			bucket = append(bucket[:i], bucket[i+1:]...)
+			if len(bucket) == 0 {
+				delete(cache, hash)
+			} else {
+				cache[hash] = bucket
+			}
+			return
+		}
+	}
+	return
+}
+
+// insertCache adds row to its bucket, reporting whether it replaced an
+// existing entry with the same canonical key (a genuine duplicate key,
+// rather than a hash collision). seq records the row's position in its
+// input stream, so orderedEntries can later replay leftovers in the order
+// they were originally read instead of map iteration order.
+func insertCache(cache Cache, hash RowHash, key []byte, row Row, seq int) (duplicate bool) {
+	bucket := cache[hash]
+	for i, entry := range bucket {
+		if bytes.Equal(entry.key, key) {
+			bucket[i] = bucketEntry{key: key, row: row, seq: seq}
+			cache[hash] = bucket
+			return true
+		}
+	}
+	cache[hash] = append(bucket, bucketEntry{key: key, row: row, seq: seq})
+	return false
+}
+
+// orderedEntries flattens every bucket in cache and returns its entries
+// sorted by seq, so rows left unmatched at EOF are reported in the order
+// they were originally read rather than Go's randomized map order.
+func orderedEntries(cache Cache) []bucketEntry {
+	entries := make([]bucketEntry, 0, len(cache))
+	for _, bucket := range cache {
+		entries = append(entries, bucket...)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+	return entries
+}
+
+func deepCopy(row Row) Row {
+	dup := make(Row, len(row))
+	for i := 0; i < len(row); i++ {
+		dup[i] = make([]byte, len(row[i]))
+		copy(dup[i], row[i])
+	}
+	return dup
+}
@@ -0,0 +1,68 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+package csvdiff
+
+import "testing"
+
+// collidingHash is shared by every key below, forcing them into the same
+// Cache bucket so the tests exercise the key-verified fallback rather than
+// trusting the hash alone.
+var collidingHash = RowHash{1}
+
+func TestCacheCollisionFallback(t *testing.T) {
+	cache := make(Cache)
+	rowFoo := Row{[]byte("foo"), []byte("one")}
+	rowBar := Row{[]byte("bar"), []byte("two")}
+
+	if dup := insertCache(cache, collidingHash, []byte("foo"), rowFoo, 0); dup {
+		t.Fatalf("insertCache(foo) reported a duplicate on first insert")
+	}
+	if dup := insertCache(cache, collidingHash, []byte("bar"), rowBar, 1); dup {
+		t.Fatalf("insertCache(bar) reported a duplicate despite a different key")
+	}
+	if len(cache[collidingHash]) != 2 {
+		t.Fatalf("bucket holds %d entries, want 2 (both keys share a hash)", len(cache[collidingHash]))
+	}
+
+	// A lookup by a key not present in the bucket must not be satisfied by
+	// the colliding entry.
+	if _, found := searchCache(cache, collidingHash, []byte("baz")); found {
+		t.Errorf("searchCache(baz) matched an entry despite no key equal to baz")
+	}
+
+	row, found := searchCache(cache, collidingHash, []byte("bar"))
+	if !found {
+		t.Fatalf("searchCache(bar) did not find its entry")
+	}
+	if string(row[0]) != "bar" {
+		t.Errorf("searchCache(bar) returned row %v, want the bar row, not foo's", row)
+	}
+	if len(cache[collidingHash]) != 1 {
+		t.Errorf("bucket holds %d entries after removing bar, want 1 (foo left behind)", len(cache[collidingHash]))
+	}
+
+	row, found = searchCache(cache, collidingHash, []byte("foo"))
+	if !found || string(row[0]) != "foo" {
+		t.Fatalf("searchCache(foo) = %v, %v, want the foo row", row, found)
+	}
+	if _, stillThere := cache[collidingHash]; stillThere {
+		t.Errorf("bucket still present after its last entry was removed")
+	}
+}
+
+func TestInsertCacheReplacesGenuineDuplicate(t *testing.T) {
+	cache := make(Cache)
+	first := Row{[]byte("foo"), []byte("one")}
+	second := Row{[]byte("foo"), []byte("two")}
+
+	insertCache(cache, collidingHash, []byte("foo"), first, 0)
+	if dup := insertCache(cache, collidingHash, []byte("foo"), second, 1); !dup {
+		t.Fatalf("insertCache did not report a duplicate for the same key re-inserted")
+	}
+	row, found := searchCache(cache, collidingHash, []byte("foo"))
+	if !found || string(row[1]) != "two" {
+		t.Errorf("searchCache after re-insert = %v, %v, want the replaced (second) row", row, found)
+	}
+}
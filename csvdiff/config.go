@@ -0,0 +1,77 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+// Package csvdiff implements the row-by-row comparison of two CSV inputs
+// keyed on one or more fields, used by the csvdiff command line tool.
+package csvdiff
+
+import (
+	"fmt"
+
+	"github.com/spf13/afero"
+)
+
+// Config controls how two CSV inputs are read, matched and reported on.
+type Config struct {
+	Keys          Keys
+	IgnoredFields map[int]bool // TODO Set
+	NoHeader      bool
+	Sep           byte
+	Guess         bool
+	Quoted        bool
+	Format        int
+	Symbol        byte
+	Common        bool
+	// Fs is the filesystem Open reads input files from. It defaults to
+	// afero.NewOsFs(), but callers can supply afero.NewMemMapFs() for tests,
+	// an afero.NewBasePathFs() sandbox, or any other afero.Fs.
+	Fs afero.Fs
+	// Hash selects the algorithm used to bucket unmatched rows while
+	// waiting for their match on the other side. Defaults to BLAKE3.
+	Hash HashAlgo
+	// Sorted tells Diff that a and b are already sorted ascending by Keys,
+	// so it can stream-merge them instead of caching unmatched rows. A row
+	// out of order aborts the Diff with an error rather than producing a
+	// silently wrong result. See SortCSV to get there from unsorted input.
+	Sorted bool
+	// OnDelta, when set, is invoked for every Added/Removed/Modified/Common
+	// row as it is produced, in addition to (or instead of, if Diff is
+	// called with a nil io.Writer) the textual output.
+	OnDelta func(Delta)
+	// OnWarning, when set, is invoked with non-fatal diagnostics such as
+	// duplicate keys; it defaults to doing nothing.
+	OnWarning func(string)
+	// NameA and NameB label the two inputs in position-qualified
+	// diagnostics (e.g. "fileA.csv:12345:7: duplicate key..."). They
+	// default to "A" and "B".
+	NameA, NameB string
+}
+
+func (c *Config) warn(format string, args ...interface{}) {
+	if c.OnWarning != nil {
+		c.OnWarning(fmt.Sprintf(format, args...))
+	}
+}
+
+// NewConfig returns a Config with the separator-derived defaults (escape
+// symbol and quoting) filled in, mirroring what the CLI computes from its
+// flags.
+func NewConfig(sep byte) *Config {
+	var symbol byte
+	if sep == '|' {
+		symbol = '!'
+	} else {
+		symbol = '|'
+	}
+	return &Config{
+		Sep:           sep,
+		Symbol:        symbol,
+		Quoted:        true,
+		IgnoredFields: make(map[int]bool),
+		Fs:            afero.NewOsFs(),
+		Hash:          BLAKE3,
+		NameA:         "A",
+		NameB:         "B",
+	}
+}
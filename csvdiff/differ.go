@@ -0,0 +1,311 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+package csvdiff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/gwenn/yacr"
+)
+
+// DeltaKind identifies the kind of change a Delta reports, using the same
+// symbols as the textual output ('+', '-', '#', '=').
+type DeltaKind byte
+
+const (
+	Added    DeltaKind = '+'
+	Removed  DeltaKind = '-'
+	Modified DeltaKind = '#'
+	Common   DeltaKind = '='
+)
+
+// Delta is a single row-level event produced while diffing, handed to
+// Config.OnDelta as it happens.
+type Delta struct {
+	Kind DeltaKind
+	Row  Row
+}
+
+// Stats summarizes a completed Diff.
+type Stats struct {
+	Total, Added, Removed, Modified uint
+	Headers                         Row
+	ModifiedFields                  []bool
+}
+
+// Differ compares two CSV streams according to its Config.
+type Differ struct {
+	config *Config
+}
+
+// NewDiffer returns a Differ driven by config.
+func NewDiffer(config *Config) *Differ {
+	return &Differ{config: config}
+}
+
+// checkRow validates that every configured key and ignored field index is
+// in range for both rows, reporting posA or posB (whichever row is too
+// short) so the error points at the offending line.
+func checkRow(rowA, rowB Row, posA, posB Position, config *Config) error {
+	for _, key := range config.Keys {
+		if int(key) >= len(rowA) {
+			return fmt.Errorf("%s: key index %d out of range", posA, key+1)
+		}
+		if int(key) >= len(rowB) {
+			return fmt.Errorf("%s: key index %d out of range", posB, key+1)
+		}
+	}
+	for field := range config.IgnoredFields {
+		if field >= len(rowA) {
+			return fmt.Errorf("%s: ignored field %d out of range", posA, field+1)
+		}
+		if field >= len(rowB) {
+			return fmt.Errorf("%s: ignored field %d out of range", posB, field+1)
+		}
+	}
+	return nil
+}
+
+// emit reports an Added, Removed or Common row: row is the raw row (no
+// leading '+'/'-'/'=' byte), which emit adds itself for OnDelta and for the
+// textual writer. In binary patch mode, pw writes the corresponding frame
+// instead.
+func (d *Differ) emit(writer *yacr.Writer, pw *patchWriter, row Row, kind DeltaKind) error {
+	if d.config.OnDelta != nil {
+		d.config.OnDelta(Delta{Kind: kind, Row: delta(row, byte(kind))})
+	}
+	if pw != nil {
+		if kind == Added {
+			return pw.writeAdded(d.config, row)
+		}
+		return pw.writeKeyOnly(d.config, kind, row)
+	}
+	if writer != nil {
+		return writeRow(writer, delta(row, byte(kind)))
+	}
+	return nil
+}
+
+// emitModified reports a Modified row: rowDelta is the already-formatted
+// textual delta (used for OnDelta and the textual writer), while rowA and
+// rowB feed the binary patch frame.
+func (d *Differ) emitModified(writer *yacr.Writer, pw *patchWriter, rowA, rowB, rowDelta Row) error {
+	if d.config.OnDelta != nil {
+		d.config.OnDelta(Delta{Kind: Modified, Row: rowDelta})
+	}
+	if pw != nil {
+		return pw.writeModified(d.config, rowA, rowB)
+	}
+	if writer != nil {
+		return writeRow(writer, rowDelta)
+	}
+	return nil
+}
+
+// Diff reads a and b as CSV, matches rows on config.Keys and reports
+// Added/Removed/Modified/Common rows, either as textual output written to
+// out or via config.OnDelta, or both. out may be nil if the caller only
+// wants the callback and the returned Stats.
+func (d *Differ) Diff(a, b io.Reader, out io.Writer) (Stats, error) {
+	config := d.config
+	readerA := yacr.NewReader(a, config.Sep, config.Quoted, config.Guess)
+	readerB := yacr.NewReader(b, config.Sep, config.Quoted, config.Guess)
+	posA := newPositionTracker(readerA, config.NameA)
+	posB := newPositionTracker(readerB, config.NameB)
+
+	var writer *yacr.Writer
+	var pw *patchWriter
+	if out != nil {
+		if config.Format == FormatPatch {
+			pw = newPatchWriter(out)
+		} else {
+			writer = makeWriter(out, config)
+		}
+	}
+
+	if config.Sorted {
+		return d.diffSorted(posA, posB, writer, pw)
+	}
+	if pw != nil {
+		// Patch mode needs b's frames in b's own order (see
+		// diffPatchOrdered's doc comment); the lockstep algorithm below
+		// only guarantees that for Config.Sorted.
+		return d.diffPatchOrdered(posA, posB, pw)
+	}
+
+	cacheA := make(Cache)
+	cacheB := make(Cache)
+
+	var stats Stats
+	var bufferA, bufferB Row = make([][]byte, 0, 10), make([][]byte, 0, 10)
+	var rowA, rowB, rowDelta Row
+	var eofA, eofB, same bool
+	var modifiedFields []bool
+	first := true
+	// pw is always nil below this point: patch mode (Config.Format ==
+	// FormatPatch) returns via diffPatchOrdered above, since this lockstep
+	// algorithm can't guarantee b's row order the way patch output needs.
+	emitCommon := config.Common
+
+	for !eofA || !eofB {
+		var err error
+		if rowA, eofA, err = readRow(posA, bufferA, eofA); err != nil {
+			return stats, err
+		}
+		if rowB, eofB, err = readRow(posB, bufferB, eofB); err != nil {
+			return stats, err
+		}
+		if rowA == nil && rowB == nil {
+			continue
+		}
+		if first {
+			if err := checkRow(rowA, rowB, posA.Position(0), posB.Position(0), config); err != nil {
+				return stats, err
+			}
+		}
+		stats.Total++
+		var hashA, hashB RowHash
+		var keyA, keyB []byte
+		matched := false
+		if rowA != nil && rowB != nil {
+			hashA, keyA = hashRow(config.Hash, rowA, config.Keys)
+			hashB, keyB = hashRow(config.Hash, rowB, config.Keys)
+			matched = hashA == hashB && bytes.Equal(keyA, keyB)
+		} else if rowA != nil {
+			hashA, keyA = hashRow(config.Hash, rowA, config.Keys)
+			if cached, found := searchCache(cacheB, hashA, keyA); found {
+				rowB = cached
+				matched = true
+			}
+		} else if rowB != nil {
+			hashB, keyB = hashRow(config.Hash, rowB, config.Keys)
+			if cached, found := searchCache(cacheA, hashB, keyB); found {
+				rowA = cached
+				matched = true
+			}
+		}
+
+		if rowA == nil {
+			if err := d.emit(writer, pw, rowB, Added); err != nil {
+				return stats, err
+			}
+			stats.Added++
+			continue
+		}
+		if rowB == nil {
+			if err := d.emit(writer, pw, rowA, Removed); err != nil {
+				return stats, err
+			}
+			stats.Removed++
+			continue
+		}
+
+		if matched {
+			if rowDelta, same = areEquals(rowA, rowB, config, modifiedFields); same {
+				if first { // FIXME, Headers may be different (hashA != hashB)...
+					first = false
+					if !config.NoHeader {
+						if err := d.emit(writer, pw, rowA, Common); err != nil {
+							return stats, err
+						}
+						stats.Headers = deepCopy(rowA)
+					} else if emitCommon {
+						if err := d.emit(writer, pw, rowA, Common); err != nil {
+							return stats, err
+						}
+					}
+					modifiedFields = make([]bool, len(rowA))
+				} else if emitCommon {
+					if err := d.emit(writer, pw, rowA, Common); err != nil {
+						return stats, err
+					}
+				}
+			} else {
+				if err := d.emitModified(writer, pw, rowA, rowB, rowDelta); err != nil {
+					return stats, err
+				}
+				stats.Modified++
+				if first {
+					first = false
+					if !config.NoHeader {
+						stats.Headers = deepCopy(rowDelta[1:])
+					}
+					modifiedFields = make([]bool, len(rowDelta)-1)
+				}
+			}
+		} else {
+			altB, found := searchCache(cacheB, hashA, keyA)
+			if found {
+				if rowDelta, same = areEquals(rowA, altB, config, modifiedFields); !same {
+					if err := d.emitModified(writer, pw, rowA, altB, rowDelta); err != nil {
+						return stats, err
+					}
+					stats.Modified++
+				} else if emitCommon {
+					if err := d.emit(writer, pw, rowA, Common); err != nil {
+						return stats, err
+					}
+				}
+			} else {
+				dup := deepCopy(rowA)
+				if insertCache(cacheA, hashA, canonicalKey(dup, config.Keys), dup, int(stats.Total)) {
+					config.warn("%s: duplicate key %q", posA.Position(int(config.Keys[0])), displayKey(rowA, config.Keys))
+				}
+			}
+			altA, found := searchCache(cacheA, hashB, keyB)
+			if found {
+				if rowDelta, same = areEquals(altA, rowB, config, modifiedFields); !same {
+					if err := d.emitModified(writer, pw, altA, rowB, rowDelta); err != nil {
+						return stats, err
+					}
+					stats.Modified++
+				} else if emitCommon {
+					if err := d.emit(writer, pw, rowB, Common); err != nil {
+						return stats, err
+					}
+				}
+			} else {
+				dup := deepCopy(rowB)
+				if insertCache(cacheB, hashB, canonicalKey(dup, config.Keys), dup, int(stats.Total)) {
+					config.warn("%s: duplicate key %q", posB.Position(int(config.Keys[0])), displayKey(rowB, config.Keys))
+				}
+			}
+		}
+	}
+	// Leftovers are replayed in the order their rows were originally read
+	// (not Go's randomized map order), so textual output and OnDelta lists
+	// them deterministically. This loop is never reached in patch mode (pw
+	// != nil returns via diffPatchOrdered above): ordering leftovers among
+	// themselves isn't enough there, since a row matched late in the
+	// lockstep scan can still land ahead of an earlier-positioned Added row
+	// still sitting in cacheB when it is finally flushed here.
+	for _, e := range orderedEntries(cacheA) {
+		if err := d.emit(writer, pw, e.row, Removed); err != nil {
+			return stats, err
+		}
+		stats.Removed++
+	}
+	for _, e := range orderedEntries(cacheB) {
+		if err := d.emit(writer, pw, e.row, Added); err != nil {
+			return stats, err
+		}
+		stats.Added++
+	}
+	stats.ModifiedFields = modifiedFields
+	if writer != nil {
+		writer.Flush()
+		if err := writer.Err(); err != nil {
+			return stats, fmt.Errorf("error while flushing diff: '%s'", err)
+		}
+	}
+	if pw != nil {
+		if err := pw.flush(); err != nil {
+			return stats, fmt.Errorf("error while flushing patch: '%s'", err)
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,63 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+package csvdiff
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// TestDiffOnDelta exercises the library's core Diff/OnDelta path directly
+// (no patch mode, no textual writer), the use case chunk0-1's embeddability
+// and chunk0-2's in-memory filesystem exist to enable.
+func TestDiffOnDelta(t *testing.T) {
+	config := NewConfig(',')
+	config.Fs = afero.NewMemMapFs()
+	config.Keys = Keys{0}
+
+	a := "id,name\n1,alice\n2,bob\n3,carol\n"
+	b := "id,name\n1,alice\n2,BOB\n4,dave\n"
+	if err := afero.WriteFile(config.Fs, "a.csv", []byte(a), 0644); err != nil {
+		t.Fatalf("write a.csv: %s", err)
+	}
+	if err := afero.WriteFile(config.Fs, "b.csv", []byte(b), 0644); err != nil {
+		t.Fatalf("write b.csv: %s", err)
+	}
+	fileA, err := Open("a.csv", config)
+	if err != nil {
+		t.Fatalf("open a.csv: %s", err)
+	}
+	defer fileA.Close()
+	fileB, err := Open("b.csv", config)
+	if err != nil {
+		t.Fatalf("open b.csv: %s", err)
+	}
+	defer fileB.Close()
+
+	var deltas []Delta
+	config.OnDelta = func(d Delta) { deltas = append(deltas, d) }
+
+	stats, err := NewDiffer(config).Diff(fileA, fileB, nil)
+	if err != nil {
+		t.Fatalf("Diff: %s", err)
+	}
+	if stats.Added != 1 || stats.Removed != 1 || stats.Modified != 1 {
+		t.Fatalf("stats = %+v, want Added=1 Removed=1 Modified=1", stats)
+	}
+	// +1 for the header row, always emitted as Common regardless of
+	// Config.Common.
+	if want := int(stats.Added+stats.Removed+stats.Modified) + 1; len(deltas) != want {
+		t.Fatalf("got %d deltas, want %d", len(deltas), want)
+	}
+
+	kinds := make(map[DeltaKind]int)
+	for _, d := range deltas {
+		kinds[d.Kind]++
+	}
+	if kinds[Added] != 1 || kinds[Removed] != 1 || kinds[Modified] != 1 || kinds[Common] != 1 {
+		t.Errorf("delta kinds = %+v, want one each of Added/Removed/Modified/Common", kinds)
+	}
+}
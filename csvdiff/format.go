@@ -0,0 +1,91 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+package csvdiff
+
+import "bytes"
+
+func update(modifiedFields []bool, i int) {
+	if modifiedFields != nil && i < len(modifiedFields) {
+		modifiedFields[i] = true
+	}
+}
+
+func concat(valueA, valueB []byte, format int, symbol byte) []byte {
+	switch format {
+	case 1:
+		return bytes.Join([][]byte{valueA, valueB}, []byte{symbol, '-', symbol})
+	case 2:
+		return bytes.Join([][]byte{valueA, valueB}, []byte{'\n'})
+	}
+	buf := []byte{}
+	buf = append(buf, '\x1b', '[', '1', 'm')
+	buf = append(buf, valueA...)
+	buf = append(buf, '\x1b', '[', '0', 'm')
+	buf = append(buf, symbol)
+	buf = append(buf, '\x1b', '[', '1', 'm')
+	buf = append(buf, valueB...)
+	buf = append(buf, '\x1b', '[', '0', 'm')
+	return buf
+}
+
+func delta(row Row, sign byte) (rowDelta Row) {
+	rowDelta = make(Row, len(row)+1) // TODO Reuse/cache one array and slice it?
+	rowDelta[0] = []byte{sign}
+	copy(rowDelta[1:], row)
+	return
+}
+
+// May be introduce a Formatter
+// TODO precision
+func areEquals(rowA, rowB Row, config *Config, modifiedFields []bool) (rowDelta Row, same bool) {
+	same = true
+	var minLen, maxLen, longest int
+	if len(rowA) > len(rowB) {
+		maxLen = len(rowA)
+		minLen = len(rowB)
+		longest = 1
+		same = false
+	} else {
+		maxLen = len(rowB)
+		minLen = len(rowA)
+		if maxLen > minLen {
+			longest = 2
+			same = false
+		}
+	}
+	if !same {
+		rowDelta = make(Row, maxLen+1) // TODO Reuse/cache one array and slice it?
+		rowDelta[0] = []byte{'#'}
+	}
+	for i := 0; i < minLen; i++ {
+		_, ignored := config.IgnoredFields[i]
+		// TODO skip keys
+		if !ignored && !bytes.Equal(rowA[i], rowB[i]) {
+			if same {
+				rowDelta = make(Row, maxLen+1)
+				rowDelta[0] = []byte{'#'}
+				copy(rowDelta[1:], rowA[0:i])
+			}
+			same = false
+			rowDelta[i+1] = concat(rowA[i], rowB[i], config.Format, config.Symbol)
+			update(modifiedFields, i)
+		} else if !same {
+			rowDelta[i+1] = rowA[i]
+		}
+	}
+	for i := minLen; i < maxLen; i++ {
+		if _, ignored := config.IgnoredFields[i]; ignored {
+			continue
+		}
+		if longest == 1 {
+			rowDelta[i+1] = concat(rowA[i], []byte{'_'}, config.Format, config.Symbol)
+			update(modifiedFields, i)
+		} else if longest == 2 {
+			rowDelta[i+1] = concat([]byte{'_'}, rowB[i], config.Format, config.Symbol)
+			update(modifiedFields, i)
+		}
+	}
+	return
+}
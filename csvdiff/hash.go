@@ -0,0 +1,93 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+package csvdiff
+
+import (
+	"bytes"
+	"hash/fnv"
+
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/xxh3"
+)
+
+// keySeparator joins concatenated key fields so that, e.g., keys "1","23"
+// and "12","3" hash and compare differently instead of colliding on "123".
+const keySeparator = 0x1f
+
+// HashAlgo selects the algorithm used to key rows for matching.
+type HashAlgo int
+
+const (
+	// BLAKE3 hashes the key with BLAKE3 and keeps the first 16 bytes. It is
+	// the default: with a 128-bit digest, buckets almost always hold a
+	// single row even on multi-million-row inputs.
+	BLAKE3 HashAlgo = iota
+	// XXH3 hashes the key with the 128-bit variant of xxHash3. Faster than
+	// BLAKE3, still collision-resistant enough that the bucket fallback
+	// below is only ever a safety net.
+	XXH3
+	// FNV64a keys rows with the original 64-bit, collision-prone FNV-1a
+	// hash. Multi-column keys are hashed separator-joined (see
+	// canonicalKey), so their digests differ from the original keying's.
+	FNV64a
+)
+
+// RowHash is a fixed-size digest of a row's key fields. It is only used to
+// bucket rows; two rows landing in the same bucket are still compared key
+// byte for key byte before being treated as a match, so a hash collision
+// can no longer corrupt the diff.
+type RowHash [16]byte
+
+// canonicalKey returns the byte-exact representation of a row's key
+// fields, used both to compute its RowHash and to resolve collisions
+// within a bucket by direct comparison.
+func canonicalKey(row Row, keys Keys) []byte {
+	var buf bytes.Buffer
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(keySeparator)
+		}
+		buf.Write(row[k])
+	}
+	return buf.Bytes()
+}
+
+// displayKey renders a row's key fields for diagnostics, joined by "|"
+// instead of canonicalKey's unambiguous-but-unreadable keySeparator.
+func displayKey(row Row, keys Keys) string {
+	var buf bytes.Buffer
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte('|')
+		}
+		buf.Write(row[k])
+	}
+	return buf.String()
+}
+
+func hashKey(algo HashAlgo, key []byte) RowHash {
+	var digest RowHash
+	switch algo {
+	case XXH3:
+		sum := xxh3.Hash128(key).Bytes()
+		copy(digest[:], sum[:])
+	case FNV64a:
+		hasher := fnv.New64a()
+		hasher.Write(key)
+		copy(digest[:8], hasher.Sum(nil))
+	default:
+		hasher := blake3.New()
+		hasher.Write(key)
+		copy(digest[:], hasher.Sum(nil)[:16])
+	}
+	return digest
+}
+
+// hashRow computes both the bucket digest and the canonical key bytes for
+// row, the latter used to break ties within a bucket.
+func hashRow(algo HashAlgo, row Row, keys Keys) (RowHash, []byte) {
+	key := canonicalKey(row, keys)
+	return hashKey(algo, key), key
+}
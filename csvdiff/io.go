@@ -0,0 +1,117 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+package csvdiff
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/gwenn/yacr"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// zfile pairs a (possibly gzip-decompressing) reader with the underlying
+// afero.File so Close reaches the real handle.
+type zfile struct {
+	io.Reader
+	file io.Closer
+}
+
+func (z *zfile) Close() error {
+	return z.file.Close()
+}
+
+func readRow(r rowScanner, buffer Row, pEof bool) (Row, bool, error) {
+	if pEof {
+		return nil, pEof, nil
+	}
+	var eof bool
+	buffer = buffer[:0]
+	for {
+		if r.Scan() {
+			buffer = append(buffer, r.Bytes())
+			if r.EndOfRecord() {
+				break
+			}
+		} else {
+			eof = true
+			break
+		}
+	}
+	if err := r.Err(); err != nil {
+		if p, ok := r.(*positionTracker); ok {
+			return nil, eof, fmt.Errorf("%s: error while reading file: %s", p.Position(len(buffer)), err)
+		}
+		return nil, eof, fmt.Errorf("error while reading file: %s", err)
+	}
+	if len(buffer) == 0 {
+		return nil, eof, nil
+	}
+	return buffer, eof, nil
+}
+
+func writeRow(w *yacr.Writer, row Row) error {
+	for _, field := range row {
+		w.Write(field)
+	}
+	w.EndOfRecord()
+	if err := w.Err(); err != nil {
+		return fmt.Errorf("error while writing diff: %s", err)
+	}
+	return nil
+}
+
+// Open opens filepath on c.Fs, transparently decompressing gzip content (by
+// magic bytes, as yacr.Zopen does for the OS filesystem) and, when filepath
+// is of the form "archive#member", extracting that single member from a
+// tar/tar.gz or zip archive instead. "-" reads from standard input.
+func Open(filepath string, c *Config) (io.ReadCloser, error) {
+	archivePath, member, hasMember := splitMember(filepath)
+	if !hasMember {
+		return openRaw(archivePath, c)
+	}
+	return openArchiveMember(archivePath, member, c)
+}
+
+// openRaw opens archivePath (or standard input, for "-") and transparently
+// gunzips it if its first bytes carry the gzip magic.
+func openRaw(archivePath string, c *Config) (io.ReadCloser, error) {
+	var f io.ReadCloser
+	if archivePath == "-" {
+		f = ioutil.NopCloser(os.Stdin)
+	} else {
+		file, err := c.Fs.Open(archivePath)
+		if err != nil {
+			return nil, fmt.Errorf("error while opening file: '%s' (%s)", archivePath, err)
+		}
+		f = file
+	}
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, fmt.Errorf("error while opening file: '%s' (%s)", archivePath, err)
+	}
+	if bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("error while opening file: '%s' (%s)", archivePath, err)
+		}
+		return &zfile{Reader: gz, file: f}, nil
+	}
+	return &zfile{Reader: br, file: f}, nil
+}
+
+func makeWriter(wr io.Writer, c *Config) *yacr.Writer {
+	writer := yacr.NewWriter(wr, c.Sep, false /*TODO c.Quoted */)
+	return writer
+}
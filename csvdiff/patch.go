@@ -0,0 +1,188 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+package csvdiff
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+// FormatPatch selects the binary patch format for Config.Format: instead of
+// a textual diff, Diff writes a replayable, CRC-checked stream that
+// ApplyPatch can later replay against file A to reconstruct file B.
+// Columns in Config.IgnoredFields are neither compared nor recorded, so the
+// reconstructed row carries file A's value for them (empty where A's row has
+// no such column).
+const FormatPatch = 3
+
+var patchMagic = [4]byte{'C', 'D', 'P', 'F'}
+
+const patchVersion = 1
+
+// patchWriter encodes Delta events as framed, CRC-checked records. The
+// wire format is:
+//
+//	header:  magic(4) version(1) sep(1) numKeys(uvarint) keys(uvarint...)
+//	         numIgnored(uvarint) ignored(uvarint...) hasHeaderRow(1)
+//	         [numFields(uvarint) field(len-prefixed)...] headerCRC(4, BE)
+//	frame*:  opcode(1) fieldCount(uvarint) payloadLen(uvarint) payload crc32(4, BE)
+//
+// For '+' (Added) the payload is the full new row. For '-' (Removed) and
+// '=' (Common) the payload is just the row's canonical key, since the rest
+// can be read back from file A. For '#' (Modified) the payload is the
+// canonical key, a bitmap of the columns changed in that row and, for each
+// changed column, its old and new value.
+type patchWriter struct {
+	w             *bufio.Writer
+	headerWritten bool
+}
+
+func newPatchWriter(out io.Writer) *patchWriter {
+	return &patchWriter{w: bufio.NewWriter(out)}
+}
+
+func putUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func putField(buf []byte, field []byte) []byte {
+	buf = putUvarint(buf, uint64(len(field)))
+	return append(buf, field...)
+}
+
+// ensureHeader writes the patch header exactly once, using headerRow (which
+// may be nil when Config.NoHeader is set) as the column-name row.
+func (pw *patchWriter) ensureHeader(config *Config, headerRow Row) error {
+	if pw.headerWritten {
+		return nil
+	}
+	pw.headerWritten = true
+
+	var buf []byte
+	buf = append(buf, patchMagic[:]...)
+	buf = append(buf, patchVersion)
+	buf = append(buf, config.Sep)
+	buf = putUvarint(buf, uint64(len(config.Keys)))
+	for _, k := range config.Keys {
+		buf = putUvarint(buf, k)
+	}
+	buf = putUvarint(buf, uint64(len(config.IgnoredFields)))
+	for field := range config.IgnoredFields {
+		buf = putUvarint(buf, uint64(field))
+	}
+	if headerRow != nil {
+		buf = append(buf, 1)
+		buf = putUvarint(buf, uint64(len(headerRow)))
+		for _, field := range headerRow {
+			buf = putField(buf, field)
+		}
+	} else {
+		buf = append(buf, 0)
+	}
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(buf))
+	buf = append(buf, crc[:]...)
+	_, err := pw.w.Write(buf)
+	return err
+}
+
+func (pw *patchWriter) writeFrame(opcode DeltaKind, fieldCount int, payload []byte) error {
+	var buf []byte
+	buf = append(buf, byte(opcode))
+	buf = putUvarint(buf, uint64(fieldCount))
+	buf = putUvarint(buf, uint64(len(payload)))
+	buf = append(buf, payload...)
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(buf))
+	buf = append(buf, crc[:]...)
+	_, err := pw.w.Write(buf)
+	return err
+}
+
+// writeKeyOnly emits a Removed or Common frame, whose payload is just row's
+// canonical key; the rest of the row is read back from file A when the
+// patch is applied.
+func (pw *patchWriter) writeKeyOnly(config *Config, kind DeltaKind, row Row) error {
+	if err := pw.ensureHeader(config, headerRowOrNil(config, row)); err != nil {
+		return err
+	}
+	key := canonicalKey(row, config.Keys)
+	var payload []byte
+	payload = putField(payload, key)
+	return pw.writeFrame(kind, len(config.Keys), payload)
+}
+
+// writeAdded emits an Added frame carrying the full new row.
+func (pw *patchWriter) writeAdded(config *Config, row Row) error {
+	if err := pw.ensureHeader(config, headerRowOrNil(config, row)); err != nil {
+		return err
+	}
+	var payload []byte
+	for _, field := range row {
+		payload = putField(payload, field)
+	}
+	return pw.writeFrame(Added, len(row), payload)
+}
+
+// writeModified emits a Modified frame: the canonical key, a bitmap of
+// which columns changed and, for each, its old and new value. The bitmap
+// is computed fresh from rowA/rowB, not from the caller's running
+// modifiedFields summary, since that accumulates across every row of the
+// diff and would mark columns this particular row never touched.
+//
+// The frame's fieldCount is always rowB's width, not max(len(rowA),
+// len(rowB)): ApplyPatch sizes the reconstructed row from this value, so
+// padding it out to file A's width would leave a spurious trailing empty
+// field whenever a row loses columns in B.
+func (pw *patchWriter) writeModified(config *Config, rowA, rowB Row) error {
+	if err := pw.ensureHeader(config, headerRowOrNil(config, rowA)); err != nil {
+		return err
+	}
+	key := canonicalKey(rowA, config.Keys)
+	fieldCount := len(rowB)
+	bitmap := make([]byte, (fieldCount+7)/8)
+	for i := 0; i < fieldCount; i++ {
+		if _, ignored := config.IgnoredFields[i]; ignored {
+			continue
+		}
+		if i >= len(rowA) || !bytes.Equal(rowA[i], rowB[i]) {
+			bitmap[i/8] |= 1 << uint(i%8)
+		}
+	}
+	var payload []byte
+	payload = putField(payload, key)
+	payload = append(payload, bitmap...)
+	for i := 0; i < fieldCount; i++ {
+		if bitmap[i/8]&(1<<uint(i%8)) == 0 {
+			continue
+		}
+		payload = putField(payload, fieldAt(rowA, i))
+		payload = putField(payload, rowB[i])
+	}
+	return pw.writeFrame(Modified, fieldCount, payload)
+}
+
+func fieldAt(row Row, i int) []byte {
+	if i < len(row) {
+		return row[i]
+	}
+	return nil
+}
+
+func headerRowOrNil(config *Config, row Row) Row {
+	if config.NoHeader {
+		return nil
+	}
+	return row
+}
+
+func (pw *patchWriter) flush() error {
+	return pw.w.Flush()
+}
@@ -0,0 +1,353 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+package csvdiff
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/gwenn/yacr"
+)
+
+// Sanity bounds applied to every length read off a patch stream before it is
+// used to size an allocation. A corrupt or truncated patch can turn any of
+// these varints into an enormous number; without a bound, make() panics with
+// "len out of range" instead of the corrupt-patch error the CRC framing is
+// meant to produce. Real patches never come close to either limit.
+const (
+	maxPatchFieldCount = 1 << 20 // max keys/ignored columns/fields per header or frame
+	maxPatchByteLen    = 1 << 28 // max length of a single field or frame payload, in bytes
+)
+
+// patchHeader is the decoded form of the section written by
+// patchWriter.ensureHeader.
+type patchHeader struct {
+	sep           byte
+	keys          Keys
+	ignoredFields map[int]bool
+	headerRow     Row
+}
+
+// hashedReader tees every byte it reads into a running CRC32, so a header
+// or frame's trailing checksum can be verified against exactly the bytes
+// that were decoded.
+type hashedReader struct {
+	r *bufio.Reader
+	h hash.Hash32
+}
+
+func (t *hashedReader) ReadByte() (byte, error) {
+	b, err := t.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	t.h.Write([]byte{b})
+	return b, nil
+}
+
+func (t *hashedReader) Read(p []byte) (int, error) {
+	n, err := io.ReadFull(t.r, p)
+	if n > 0 {
+		t.h.Write(p[:n])
+	}
+	return n, err
+}
+
+// ApplyPatch replays a binary patch stream (as written by Diff with
+// Config.Format == FormatPatch) against file A, reconstructing file B on
+// out. Every frame's CRC is verified; a corrupt stream, or one cut off inside
+// the header or a frame, aborts with an error. A stream cut off exactly at a
+// frame boundary is not detected: the frames before the cut are applied and
+// ApplyPatch returns nil.
+func ApplyPatch(patch io.Reader, a io.Reader, out io.Writer) error {
+	patchReader := bufio.NewReader(patch)
+	header, err := readPatchHeader(patchReader)
+	if err != nil {
+		return err
+	}
+
+	index, err := indexByKey(a, header)
+	if err != nil {
+		return err
+	}
+
+	writer := yacr.NewWriter(out, header.sep, false)
+	for {
+		kind, fieldCount, payload, err := readPatchFrame(patchReader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case Added:
+			row, err := parseFields(payload, fieldCount)
+			if err != nil {
+				return fmt.Errorf("corrupt patch: %s", err)
+			}
+			if err := writeRow(writer, row); err != nil {
+				return err
+			}
+		case Removed:
+			if _, err := parseKeyPayload(payload); err != nil {
+				return fmt.Errorf("corrupt patch: %s", err)
+			}
+		case Common:
+			key, err := parseKeyPayload(payload)
+			if err != nil {
+				return fmt.Errorf("corrupt patch: %s", err)
+			}
+			row, ok := index[string(key)]
+			if !ok {
+				return fmt.Errorf("patch references key %q not found in file A", key)
+			}
+			if err := writeRow(writer, row); err != nil {
+				return err
+			}
+		case Modified:
+			key, newValues, err := parseModifiedPayload(payload, fieldCount)
+			if err != nil {
+				return fmt.Errorf("corrupt patch: %s", err)
+			}
+			base, ok := index[string(key)]
+			if !ok {
+				return fmt.Errorf("patch references key %q not found in file A", key)
+			}
+			row := make(Row, fieldCount)
+			for i := 0; i < fieldCount; i++ {
+				if nv, changed := newValues[i]; changed {
+					row[i] = nv
+				} else if i < len(base) {
+					row[i] = base[i]
+				}
+			}
+			if err := writeRow(writer, row); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("corrupt patch: unknown opcode %q", byte(kind))
+		}
+	}
+	writer.Flush()
+	if err := writer.Err(); err != nil {
+		return fmt.Errorf("error while writing patched output: %s", err)
+	}
+	return nil
+}
+
+func indexByKey(a io.Reader, header *patchHeader) (map[string]Row, error) {
+	reader := yacr.NewReader(a, header.sep, true, false)
+	index := make(map[string]Row)
+	buffer := make([][]byte, 0, 10)
+	eof := false
+	for !eof {
+		row, e, err := readRow(reader, buffer, eof)
+		eof = e
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			continue
+		}
+		dup := deepCopy(row)
+		index[string(canonicalKey(dup, header.keys))] = dup
+	}
+	return index, nil
+}
+
+// readBoundedUvarint reads a uvarint and rejects it outright if it exceeds
+// limit, so a corrupted length never reaches a make() call.
+func readBoundedUvarint(r io.ByteReader, limit uint64, what string) (uint64, error) {
+	v, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if v > limit {
+		return 0, fmt.Errorf("corrupt patch: %s (%d) exceeds sane limit", what, v)
+	}
+	return v, nil
+}
+
+func readPatchHeader(patchReader *bufio.Reader) (*patchHeader, error) {
+	hr := &hashedReader{r: patchReader, h: crc32.NewIEEE()}
+	var magic [4]byte
+	if _, err := io.ReadFull(hr, magic[:]); err != nil {
+		return nil, fmt.Errorf("error while reading patch header: %s", err)
+	}
+	if magic != patchMagic {
+		return nil, fmt.Errorf("not a csvdiff patch stream (bad magic)")
+	}
+	version, err := hr.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("error while reading patch header: %s", err)
+	}
+	if version != patchVersion {
+		return nil, fmt.Errorf("unsupported patch version %d", version)
+	}
+	sep, err := hr.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("error while reading patch header: %s", err)
+	}
+	numKeys, err := readBoundedUvarint(hr, maxPatchFieldCount, "key count")
+	if err != nil {
+		return nil, fmt.Errorf("error while reading patch header: %s", err)
+	}
+	keys := make(Keys, numKeys)
+	for i := range keys {
+		v, err := binary.ReadUvarint(hr)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading patch header: %s", err)
+		}
+		keys[i] = v
+	}
+	numIgnored, err := readBoundedUvarint(hr, maxPatchFieldCount, "ignored-field count")
+	if err != nil {
+		return nil, fmt.Errorf("error while reading patch header: %s", err)
+	}
+	ignoredFields := make(map[int]bool)
+	for i := uint64(0); i < numIgnored; i++ {
+		v, err := binary.ReadUvarint(hr)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading patch header: %s", err)
+		}
+		ignoredFields[int(v)] = true
+	}
+	hasHeaderRow, err := hr.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("error while reading patch header: %s", err)
+	}
+	var headerRow Row
+	if hasHeaderRow == 1 {
+		numFields, err := readBoundedUvarint(hr, maxPatchFieldCount, "header field count")
+		if err != nil {
+			return nil, fmt.Errorf("error while reading patch header: %s", err)
+		}
+		headerRow = make(Row, numFields)
+		for i := range headerRow {
+			field, err := readField(hr)
+			if err != nil {
+				return nil, fmt.Errorf("error while reading patch header: %s", err)
+			}
+			headerRow[i] = field
+		}
+	}
+	var crcBuf [4]byte
+	if _, err := io.ReadFull(patchReader, crcBuf[:]); err != nil {
+		return nil, fmt.Errorf("error while reading patch header: %s", err)
+	}
+	if want, got := binary.BigEndian.Uint32(crcBuf[:]), hr.h.Sum32(); want != got {
+		return nil, fmt.Errorf("corrupt patch: header crc mismatch (want %08x, got %08x)", want, got)
+	}
+	return &patchHeader{sep: sep, keys: keys, ignoredFields: ignoredFields, headerRow: headerRow}, nil
+}
+
+// readPatchFrame reads one frame, verifying its CRC, and returns io.EOF
+// (with no error) once the stream is exhausted cleanly.
+func readPatchFrame(patchReader *bufio.Reader) (kind DeltaKind, fieldCount int, payload []byte, err error) {
+	if _, err = patchReader.Peek(1); err == io.EOF {
+		return 0, 0, nil, io.EOF
+	} else if err != nil {
+		return
+	}
+	// At least one byte of a frame is present, so running out of input from
+	// here on is a truncated frame, not a clean end of stream.
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+	hr := &hashedReader{r: patchReader, h: crc32.NewIEEE()}
+	op, err := hr.ReadByte()
+	if err != nil {
+		return
+	}
+	kind = DeltaKind(op)
+	fc, err := readBoundedUvarint(hr, maxPatchFieldCount, "frame field count")
+	if err != nil {
+		return
+	}
+	fieldCount = int(fc)
+	pl, err := readBoundedUvarint(hr, maxPatchByteLen, "frame payload length")
+	if err != nil {
+		return
+	}
+	payload = make([]byte, pl)
+	if _, err = io.ReadFull(hr, payload); err != nil {
+		return
+	}
+	var crcBuf [4]byte
+	if _, err = io.ReadFull(patchReader, crcBuf[:]); err != nil {
+		return
+	}
+	if want, got := binary.BigEndian.Uint32(crcBuf[:]), hr.h.Sum32(); want != got {
+		err = fmt.Errorf("corrupt patch: frame crc mismatch (want %08x, got %08x)", want, got)
+	}
+	return
+}
+
+func readField(r io.Reader) ([]byte, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	n, err := readBoundedUvarint(br, maxPatchByteLen, "field length")
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func parseFields(payload []byte, count int) (Row, error) {
+	br := bytes.NewReader(payload)
+	row := make(Row, count)
+	for i := 0; i < count; i++ {
+		field, err := readField(br)
+		if err != nil {
+			return nil, err
+		}
+		row[i] = field
+	}
+	return row, nil
+}
+
+func parseKeyPayload(payload []byte) ([]byte, error) {
+	return readField(bytes.NewReader(payload))
+}
+
+func parseModifiedPayload(payload []byte, fieldCount int) (key []byte, newValues map[int][]byte, err error) {
+	br := bytes.NewReader(payload)
+	if key, err = readField(br); err != nil {
+		return
+	}
+	bitmap := make([]byte, (fieldCount+7)/8)
+	if _, err = io.ReadFull(br, bitmap); err != nil {
+		return
+	}
+	newValues = make(map[int][]byte)
+	for i := 0; i < fieldCount; i++ {
+		if bitmap[i/8]&(1<<uint(i%8)) == 0 {
+			continue
+		}
+		if _, err = readField(br); err != nil { // old value, unused when applying forward
+			return
+		}
+		var newValue []byte
+		if newValue, err = readField(br); err != nil {
+			return
+		}
+		newValues[i] = newValue
+	}
+	return
+}
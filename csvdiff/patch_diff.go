@@ -0,0 +1,154 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+package csvdiff
+
+import (
+	"fmt"
+)
+
+// diffPatchOrdered is the binary patch mode's counterpart to the cache-based
+// algorithm in Diff: instead of matching a and b in lockstep and flushing
+// whatever is left in cacheB only at EOF (which reorders a trailing Added
+// row ahead of an earlier row that happened to match late in the stream),
+// it indexes every row of a first, then makes a single forward pass over b,
+// emitting a Common/Modified/Added frame for each b row in b's own order.
+// ApplyPatch replays frames in the order they were written, so only a
+// stream shaped like b's own order can reconstruct it losslessly; the
+// lockstep algorithm cannot give that guarantee outside Config.Sorted.
+// Leftover a rows are emitted as Removed at the end: their relative order
+// doesn't matter, since a Removed frame writes nothing to the reconstructed
+// output.
+func (d *Differ) diffPatchOrdered(posA, posB *positionTracker, pw *patchWriter) (Stats, error) {
+	config := d.config
+	var stats Stats
+	var modifiedFields []bool
+	var sampleA Row
+
+	var bufferA Row = make([][]byte, 0, 10)
+	var eofA bool
+	var headerA Row
+	if !config.NoHeader {
+		row, eof, err := readRow(posA, bufferA, eofA)
+		eofA = eof
+		if err != nil {
+			return stats, err
+		}
+		headerA = deepCopy(row)
+	}
+
+	cacheA := make(Cache)
+	seq := 0
+	for !eofA {
+		row, eof, err := readRow(posA, bufferA, eofA)
+		eofA = eof
+		if err != nil {
+			return stats, err
+		}
+		if row == nil {
+			continue
+		}
+		if sampleA == nil {
+			sampleA = deepCopy(row)
+		}
+		dup := deepCopy(row)
+		hash, key := hashRow(config.Hash, dup, config.Keys)
+		seq++
+		if insertCache(cacheA, hash, key, dup, seq) {
+			config.warn("%s: duplicate key %q", posA.Position(int(config.Keys[0])), displayKey(row, config.Keys))
+		}
+	}
+
+	var bufferB Row = make([][]byte, 0, 10)
+	var eofB bool
+	var headerB Row
+	if !config.NoHeader {
+		row, eof, err := readRow(posB, bufferB, eofB)
+		eofB = eof
+		if err != nil {
+			return stats, err
+		}
+		headerB = row
+	}
+
+	if headerA != nil || headerB != nil {
+		if err := checkRow(headerA, headerB, posA.Position(0), posB.Position(0), config); err != nil {
+			return stats, err
+		}
+		stats.Total++
+		maxLen := len(headerA)
+		if len(headerB) > maxLen {
+			maxLen = len(headerB)
+		}
+		modifiedFields = make([]bool, maxLen)
+		rowDelta, same := areEquals(headerA, headerB, config, modifiedFields)
+		if same {
+			if err := d.emit(nil, pw, headerA, Common); err != nil {
+				return stats, err
+			}
+			stats.Headers = deepCopy(headerA)
+		} else {
+			if err := d.emitModified(nil, pw, headerA, headerB, rowDelta); err != nil {
+				return stats, err
+			}
+			stats.Modified++
+			stats.Headers = deepCopy(rowDelta[1:])
+		}
+	}
+
+	first := config.NoHeader
+	for !eofB {
+		row, eof, err := readRow(posB, bufferB, eofB)
+		eofB = eof
+		if err != nil {
+			return stats, err
+		}
+		if row == nil {
+			continue
+		}
+		if first {
+			first = false
+			if err := checkRow(sampleA, row, posA.Position(0), posB.Position(0), config); err != nil {
+				return stats, err
+			}
+		}
+		stats.Total++
+		hash, key := hashRow(config.Hash, row, config.Keys)
+		rowA, found := searchCache(cacheA, hash, key)
+		if !found {
+			if err := d.emit(nil, pw, row, Added); err != nil {
+				return stats, err
+			}
+			stats.Added++
+			continue
+		}
+		if modifiedFields == nil {
+			modifiedFields = make([]bool, len(rowA))
+		}
+		rowDelta, same := areEquals(rowA, row, config, modifiedFields)
+		if same {
+			if err := d.emit(nil, pw, rowA, Common); err != nil {
+				return stats, err
+			}
+			continue
+		}
+		if err := d.emitModified(nil, pw, rowA, row, rowDelta); err != nil {
+			return stats, err
+		}
+		stats.Modified++
+	}
+
+	for _, e := range orderedEntries(cacheA) {
+		if err := d.emit(nil, pw, e.row, Removed); err != nil {
+			return stats, err
+		}
+		stats.Removed++
+	}
+
+	stats.ModifiedFields = modifiedFields
+	if err := pw.flush(); err != nil {
+		return stats, fmt.Errorf("error while flushing patch: '%s'", err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,172 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+package csvdiff
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// diffAndApply diffs a and b in FormatPatch, applies the resulting patch to
+// a and returns the reconstructed bytes, so tests can assert it matches b.
+func diffAndApply(t *testing.T, config *Config, a, b string) []byte {
+	t.Helper()
+	if err := afero.WriteFile(config.Fs, "a.csv", []byte(a), 0644); err != nil {
+		t.Fatalf("write a.csv: %s", err)
+	}
+	if err := afero.WriteFile(config.Fs, "b.csv", []byte(b), 0644); err != nil {
+		t.Fatalf("write b.csv: %s", err)
+	}
+	fileA, err := Open("a.csv", config)
+	if err != nil {
+		t.Fatalf("open a.csv: %s", err)
+	}
+	defer fileA.Close()
+	fileB, err := Open("b.csv", config)
+	if err != nil {
+		t.Fatalf("open b.csv: %s", err)
+	}
+	defer fileB.Close()
+
+	var patch bytes.Buffer
+	config.Format = FormatPatch
+	if _, err := NewDiffer(config).Diff(fileA, fileB, &patch); err != nil {
+		t.Fatalf("Diff: %s", err)
+	}
+
+	baseA, err := Open("a.csv", config)
+	if err != nil {
+		t.Fatalf("reopen a.csv: %s", err)
+	}
+	defer baseA.Close()
+	var out bytes.Buffer
+	if err := ApplyPatch(&patch, baseA, &out); err != nil {
+		t.Fatalf("ApplyPatch: %s", err)
+	}
+	return out.Bytes()
+}
+
+func TestPatchRoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b string
+	}{
+		{
+			name: "common rows survive without -c",
+			a:    "id,name\n1,alpha\n2,beta\n",
+			b:    "id,name\n1,alpha\n2,BETA\n",
+		},
+		{
+			name: "row shrinks in B",
+			a:    "id,name,age\n1,alice,30\n2,bob,40\n",
+			b:    "id,name\n1,alice\n2,bob\n",
+		},
+		{
+			name: "row grows in B",
+			a:    "id,name\n1,alice\n2,bob\n",
+			b:    "id,name,age\n1,alice,30\n2,bob,40\n",
+		},
+		{
+			name: "B rows reordered relative to A, some matched late",
+			a:    "id,name\n1,alice\n2,bob\n3,carol\n4,dave\n5,eve\n",
+			b:    "id,name\n1,alice\n3,carol\n4,dave\n5,EVE\n2,BOB\n",
+		},
+		{
+			name: "added and removed rows",
+			a:    "id,name\n1,alice\n2,bob\n3,carol\n",
+			b:    "id,name\n3,carol\n0,zed\n1,ALICE\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := NewConfig(',')
+			config.Fs = afero.NewMemMapFs()
+			config.Keys = Keys{0}
+			got := diffAndApply(t, config, tc.a, tc.b)
+			if string(got) != tc.b {
+				t.Errorf("reconstructed B = %q, want %q", got, tc.b)
+			}
+		})
+	}
+}
+
+// TestPatchIdenticalFilesReportNoModified reproduces a regression where
+// diffPatchOrdered's headerA aliased bufferA's backing array: draining the
+// rest of file A into cacheA then overwrote headerA in place, so it no
+// longer held the real header by the time it was compared against headerB.
+// With more than one data row in A, byte-identical files were wrongly
+// reported as having a modified row.
+func TestPatchIdenticalFilesReportNoModified(t *testing.T) {
+	config := NewConfig(',')
+	config.Fs = afero.NewMemMapFs()
+	config.Keys = Keys{0}
+	config.Format = FormatPatch
+
+	same := "id,name\n1,alice\n2,bob\n3,carol\n"
+	if err := afero.WriteFile(config.Fs, "a.csv", []byte(same), 0644); err != nil {
+		t.Fatalf("write a.csv: %s", err)
+	}
+	if err := afero.WriteFile(config.Fs, "b.csv", []byte(same), 0644); err != nil {
+		t.Fatalf("write b.csv: %s", err)
+	}
+	fileA, err := Open("a.csv", config)
+	if err != nil {
+		t.Fatalf("open a.csv: %s", err)
+	}
+	defer fileA.Close()
+	fileB, err := Open("b.csv", config)
+	if err != nil {
+		t.Fatalf("open b.csv: %s", err)
+	}
+	defer fileB.Close()
+
+	var patch bytes.Buffer
+	stats, err := NewDiffer(config).Diff(fileA, fileB, &patch)
+	if err != nil {
+		t.Fatalf("Diff: %s", err)
+	}
+	if stats.Modified != 0 {
+		t.Errorf("stats.Modified = %d, want 0 for byte-identical files", stats.Modified)
+	}
+	wantHeaders := Row{[]byte("id"), []byte("name")}
+	if len(stats.Headers) != len(wantHeaders) || string(stats.Headers[0]) != "id" || string(stats.Headers[1]) != "name" {
+		t.Errorf("stats.Headers = %q, want %q", stats.Headers, wantHeaders)
+	}
+}
+
+// TestReadPatchHeaderRejectsHugeLength corrupts the numKeys varint of an
+// otherwise well-formed header into an enormous value. Before every
+// stream-read length was bounded, this made make(Keys, numKeys) panic with
+// "len out of range" instead of returning the corrupt-patch error the CRC
+// framing is supposed to produce.
+func TestReadPatchHeaderRejectsHugeLength(t *testing.T) {
+	var buf []byte
+	buf = append(buf, patchMagic[:]...)
+	buf = append(buf, patchVersion)
+	buf = append(buf, ',')
+	buf = putUvarint(buf, uint64(1)<<62) // corrupt numKeys
+
+	_, err := readPatchHeader(bufio.NewReader(bytes.NewReader(buf)))
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+}
+
+// TestReadPatchFrameRejectsHugePayloadLength does the same for a frame's
+// payloadLen, which previously fed straight into make([]byte, pl).
+func TestReadPatchFrameRejectsHugePayloadLength(t *testing.T) {
+	var buf []byte
+	buf = append(buf, byte(Added))
+	buf = putUvarint(buf, 1)             // fieldCount
+	buf = putUvarint(buf, uint64(1)<<40) // corrupt payloadLen
+
+	_, _, _, err := readPatchFrame(bufio.NewReader(bytes.NewReader(buf)))
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+}
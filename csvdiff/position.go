@@ -0,0 +1,118 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+package csvdiff
+
+import "fmt"
+
+// Position locates a single field within a CSV input, so diagnostics can
+// point straight at the offending row instead of printing its raw bytes.
+// Line and Column are 1-based; Offset is the 0-based byte offset of the
+// field's first byte in the stream.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+	Offset int64
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// rowScanner is the subset of *yacr.Reader that readRow drives; satisfied
+// directly by *yacr.Reader and by *positionTracker, which wraps one to
+// additionally record where each field came from.
+type rowScanner interface {
+	Scan() bool
+	Bytes() []byte
+	EndOfRecord() bool
+	Err() error
+	LineNumber() int
+}
+
+// positionTracker wraps a rowScanner, recording the line, byte column and
+// offset of each field as it is scanned, so callers can ask Position for
+// the location of any field in the record just read. The line is latched
+// from the wrapped scanner's LineNumber at the moment a new record starts,
+// rather than hand-counted one-per-record: yacr already advances its own
+// counter for every newline it consumes, including ones embedded inside a
+// quoted field, so a row following a multi-line quoted field is still
+// reported on its true physical line.
+type positionTracker struct {
+	r             rowScanner
+	file          string
+	recordLine    int
+	col           int
+	offset        int64
+	fieldOffsets  []int
+	atRecordStart bool
+}
+
+func newPositionTracker(r rowScanner, file string) *positionTracker {
+	return &positionTracker{r: r, file: file, atRecordStart: true}
+}
+
+func (p *positionTracker) Scan() bool {
+	if p.atRecordStart {
+		p.recordLine = p.r.LineNumber()
+		p.col = 0
+		p.fieldOffsets = p.fieldOffsets[:0]
+		p.atRecordStart = false
+	}
+	return p.r.Scan()
+}
+
+// Bytes returns the field most recently scanned. As a side effect, it
+// records the field's starting column and advances the running column and
+// byte offset by the field's length, so Position reports the next field's
+// location correctly.
+//
+// The advance is based on len(b), the unescaped field yacr returns, not the
+// raw bytes consumed from the input; for a quoted field containing escaped
+// quotes or embedded separators/newlines, those differ, so Column and
+// Offset are approximate for every field following it on the same row.
+// yacr does not expose the raw consumed length, so there is no exact fix
+// short of forking it.
+func (p *positionTracker) Bytes() []byte {
+	b := p.r.Bytes()
+	p.fieldOffsets = append(p.fieldOffsets, p.col+1)
+	p.col += len(b) + 1
+	p.offset += int64(len(b)) + 1
+	return b
+}
+
+func (p *positionTracker) EndOfRecord() bool {
+	eor := p.r.EndOfRecord()
+	if eor {
+		p.atRecordStart = true
+	}
+	return eor
+}
+
+func (p *positionTracker) Err() error {
+	return p.r.Err()
+}
+
+func (p *positionTracker) LineNumber() int {
+	return p.r.LineNumber()
+}
+
+// Position returns the location of the given 0-based field index within
+// the record most recently scanned. field falls back to column 1 when it
+// is out of range, e.g. because the record was shorter than expected.
+// Line is exact even across quoted fields spanning multiple physical
+// lines; Column is exact for unquoted fields but only approximate once a
+// quoted field earlier in the row contained escaped quotes or an embedded
+// separator/newline (see Bytes).
+func (p *positionTracker) Position(field int) Position {
+	column := 1
+	if field >= 0 && field < len(p.fieldOffsets) {
+		column = p.fieldOffsets[field]
+	}
+	// col and offset advance in step within a record, so their difference
+	// is the offset at which the record began.
+	offset := p.offset - int64(p.col) + int64(column-1)
+	return Position{File: p.file, Line: p.recordLine, Column: column, Offset: offset}
+}
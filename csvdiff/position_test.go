@@ -0,0 +1,43 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+package csvdiff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gwenn/yacr"
+)
+
+// TestPositionTrackerLineNumberAcrossQuotedMultilineField exercises a
+// quoted field embedding a newline: the row it belongs to spans two
+// physical lines, so every row after it sits one physical line further
+// down than a naive per-record counter would think.
+func TestPositionTrackerLineNumberAcrossQuotedMultilineField(t *testing.T) {
+	csv := "id,val\n1,a\n2,\"b\nc\"\n3,d\n4,e\n"
+	reader := yacr.NewReader(strings.NewReader(csv), ',', true, false)
+	track := newPositionTracker(reader, "test.csv")
+
+	// header (line 1), 1,a (line 2), 2,"b\nc" (starts line 3, spans into
+	// line 4), 3,d (line 5), 4,e (line 6)
+	wantLines := []int{1, 2, 3, 5, 6}
+
+	var buffer Row = make(Row, 0, 10)
+	var eof bool
+	for i, want := range wantLines {
+		var row Row
+		var err error
+		row, eof, err = readRow(track, buffer, eof)
+		if err != nil {
+			t.Fatalf("row %d: readRow: %s", i, err)
+		}
+		if row == nil {
+			t.Fatalf("row %d: unexpected eof", i)
+		}
+		if got := track.Position(0).Line; got != want {
+			t.Errorf("row %d: line = %d, want %d", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+package csvdiff
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/gwenn/yacr"
+)
+
+// compareKeys orders rowA and rowB by config.Keys, column by column.
+func compareKeys(rowA, rowB Row, keys Keys) int {
+	for _, k := range keys {
+		if c := bytes.Compare(rowA[k], rowB[k]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// diffSorted is the Config.Sorted counterpart of the cache-based algorithm
+// in Diff: it assumes a and b are already sorted ascending by config.Keys
+// and performs a streaming merge, so memory use no longer depends on how
+// far apart a matching row's two occurrences are.
+func (d *Differ) diffSorted(posA, posB *positionTracker, writer *yacr.Writer, pw *patchWriter) (Stats, error) {
+	config := d.config
+	var stats Stats
+	var bufferA, bufferB Row = make([][]byte, 0, 10), make([][]byte, 0, 10)
+	var rowA, rowB Row
+	var eofA, eofB bool
+	var lastRowA, lastRowB Row
+	var modifiedFields []bool
+	first := true
+	// The header row's "key" is a column label, not data, so it must not be
+	// fed into the out-of-order check below.
+	headerPendingA, headerPendingB := !config.NoHeader, !config.NoHeader
+	// Patch mode must always replay Common rows: they're the only source
+	// ApplyPatch has for unchanged rows, regardless of Config.Common.
+	emitCommon := config.Common || pw != nil
+
+	advanceA := func() error {
+		row, eof, err := readRow(posA, bufferA, eofA)
+		eofA = eof
+		if err != nil {
+			return err
+		}
+		rowA = row
+		if rowA == nil {
+			return nil
+		}
+		if headerPendingA {
+			headerPendingA = false
+			return nil
+		}
+		// Compare with compareKeys, the same column-by-column ordering
+		// kWayMerge sorts by, rather than canonicalKey's separator-joined
+		// bytes: the two can disagree when a non-final key column holds a
+		// byte below keySeparator, which would then falsely reject input
+		// SortCSV itself just produced.
+		if lastRowA != nil && compareKeys(rowA, lastRowA, config.Keys) < 0 {
+			return fmt.Errorf("%s: rows are not sorted by key (out of order for --sorted)", posA.Position(int(config.Keys[0])))
+		}
+		lastRowA = deepCopy(rowA)
+		return nil
+	}
+	advanceB := func() error {
+		row, eof, err := readRow(posB, bufferB, eofB)
+		eofB = eof
+		if err != nil {
+			return err
+		}
+		rowB = row
+		if rowB == nil {
+			return nil
+		}
+		if headerPendingB {
+			headerPendingB = false
+			return nil
+		}
+		if lastRowB != nil && compareKeys(rowB, lastRowB, config.Keys) < 0 {
+			return fmt.Errorf("%s: rows are not sorted by key (out of order for --sorted)", posB.Position(int(config.Keys[0])))
+		}
+		lastRowB = deepCopy(rowB)
+		return nil
+	}
+
+	if err := advanceA(); err != nil {
+		return stats, err
+	}
+	if err := advanceB(); err != nil {
+		return stats, err
+	}
+
+	for rowA != nil || rowB != nil {
+		stats.Total++
+		if first {
+			if err := checkRow(rowA, rowB, posA.Position(0), posB.Position(0), config); err != nil {
+				return stats, err
+			}
+		}
+		cmp := 0
+		switch {
+		case rowA == nil:
+			cmp = 1
+		case rowB == nil:
+			cmp = -1
+		default:
+			cmp = compareKeys(rowA, rowB, config.Keys)
+		}
+
+		switch {
+		case cmp < 0:
+			if err := d.emit(writer, pw, rowA, Removed); err != nil {
+				return stats, err
+			}
+			stats.Removed++
+			if err := advanceA(); err != nil {
+				return stats, err
+			}
+		case cmp > 0:
+			if err := d.emit(writer, pw, rowB, Added); err != nil {
+				return stats, err
+			}
+			stats.Added++
+			if err := advanceB(); err != nil {
+				return stats, err
+			}
+		default:
+			rowDelta, same := areEquals(rowA, rowB, config, modifiedFields)
+			if same {
+				if first {
+					first = false
+					if !config.NoHeader {
+						if err := d.emit(writer, pw, rowA, Common); err != nil {
+							return stats, err
+						}
+						stats.Headers = deepCopy(rowA)
+					} else if emitCommon {
+						if err := d.emit(writer, pw, rowA, Common); err != nil {
+							return stats, err
+						}
+					}
+					modifiedFields = make([]bool, len(rowA))
+				} else if emitCommon {
+					if err := d.emit(writer, pw, rowA, Common); err != nil {
+						return stats, err
+					}
+				}
+			} else {
+				if err := d.emitModified(writer, pw, rowA, rowB, rowDelta); err != nil {
+					return stats, err
+				}
+				stats.Modified++
+				if first {
+					first = false
+					if !config.NoHeader {
+						stats.Headers = deepCopy(rowDelta[1:])
+					}
+					modifiedFields = make([]bool, len(rowDelta)-1)
+				}
+			}
+			if err := advanceA(); err != nil {
+				return stats, err
+			}
+			if err := advanceB(); err != nil {
+				return stats, err
+			}
+		}
+	}
+	stats.ModifiedFields = modifiedFields
+	if writer != nil {
+		writer.Flush()
+		if err := writer.Err(); err != nil {
+			return stats, fmt.Errorf("error while flushing diff: '%s'", err)
+		}
+	}
+	if pw != nil {
+		if err := pw.flush(); err != nil {
+			return stats, fmt.Errorf("error while flushing patch: '%s'", err)
+		}
+	}
+	return stats, nil
+}
+
+const sortChunkRows = 100000
+
+// sortedFile wraps the final merged temp file produced by SortCSV,
+// optionally prefixed with the re-encoded header row; Close removes every
+// temp file created along the way.
+type sortedFile struct {
+	io.Reader
+	file  *os.File
+	paths []string
+}
+
+func (s *sortedFile) Close() error {
+	err := s.file.Close()
+	for _, p := range s.paths {
+		os.Remove(p)
+	}
+	return err
+}
+
+// encodeRow re-serializes row as a single CSV record in config's format,
+// for re-emitting a row (such as a header) pulled out of the input earlier.
+func encodeRow(row Row, config *Config) ([]byte, error) {
+	var buf bytes.Buffer
+	w := yacr.NewWriter(&buf, config.Sep, config.Quoted)
+	for _, field := range row {
+		w.Write(field)
+	}
+	w.EndOfRecord()
+	w.Flush()
+	if err := w.Err(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SortCSV reads in, sorts it ascending by config.Keys and returns a stream
+// of the result. It never holds more than sortChunkRows rows in memory at
+// once: rows are sorted in chunks and spilled to temp files under
+// os.TempDir, then those chunks are k-way merged back together. Closing
+// the returned ReadCloser removes the temp files it created. Pair with
+// Config.Sorted and Differ.Diff to diff arbitrarily large, unsorted inputs
+// in bounded memory.
+func SortCSV(in io.Reader, config *Config) (io.ReadCloser, error) {
+	reader := yacr.NewReader(in, config.Sep, config.Quoted, config.Guess)
+	var chunkPaths []string
+	cleanup := func() {
+		for _, p := range chunkPaths {
+			os.Remove(p)
+		}
+	}
+
+	var buffer Row = make([][]byte, 0, 10)
+	var eof bool
+
+	// The header row is a column-name label, not sortable data; pull it
+	// aside so it is not reordered along with the rows that follow it.
+	var headerRow Row
+	if !config.NoHeader {
+		row, e, err := readRow(reader, buffer, eof)
+		eof = e
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		if row != nil {
+			headerRow = deepCopy(row)
+		}
+	}
+
+	var rows []Row
+	flushChunk := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		sort.Slice(rows, func(i, j int) bool { return compareKeys(rows[i], rows[j], config.Keys) < 0 })
+		f, err := ioutil.TempFile("", "csvdiff-sort-*.csv")
+		if err != nil {
+			return err
+		}
+		chunkPaths = append(chunkPaths, f.Name())
+		// Quoted, matching the reader above: a key/value legitimately
+		// containing the separator (valid input under Config.Quoted) would
+		// otherwise come back out of the chunk file as extra fields.
+		w := yacr.NewWriter(f, config.Sep, true)
+		for _, row := range rows {
+			for _, field := range row {
+				w.Write(field)
+			}
+			w.EndOfRecord()
+		}
+		w.Flush()
+		if err := w.Err(); err != nil {
+			f.Close()
+			return err
+		}
+		rows = rows[:0]
+		return f.Close()
+	}
+
+	for !eof {
+		row, e, err := readRow(reader, buffer, eof)
+		eof = e
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		if row == nil {
+			continue
+		}
+		rows = append(rows, deepCopy(row))
+		if len(rows) >= sortChunkRows {
+			if err := flushChunk(); err != nil {
+				cleanup()
+				return nil, err
+			}
+		}
+	}
+	if err := flushChunk(); err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	var header []byte
+	if headerRow != nil {
+		var err error
+		if header, err = encodeRow(headerRow, config); err != nil {
+			cleanup()
+			return nil, err
+		}
+	}
+
+	if len(chunkPaths) == 0 {
+		return ioutil.NopCloser(bytes.NewReader(header)), nil
+	}
+	if len(chunkPaths) == 1 {
+		f, err := os.Open(chunkPaths[0])
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		return prependHeader(header, f, chunkPaths), nil
+	}
+
+	merged, err := kWayMerge(chunkPaths, config)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	chunkPaths = append(chunkPaths, merged)
+	f, err := os.Open(merged)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	return prependHeader(header, f, chunkPaths), nil
+}
+
+// prependHeader wraps f in a sortedFile whose Read stream starts with
+// header, so the row SortCSV pulled aside at the start of the input is
+// re-emitted as the first record of the sorted output.
+func prependHeader(header []byte, f *os.File, paths []string) *sortedFile {
+	if header == nil {
+		return &sortedFile{Reader: f, file: f, paths: paths}
+	}
+	return &sortedFile{Reader: io.MultiReader(bytes.NewReader(header), f), file: f, paths: paths}
+}
+
+type mergeItem struct {
+	row Row
+	src int
+}
+
+// mergeHeap is a container/heap min-heap ordering mergeItems by key, used
+// to k-way merge sorted chunk files back into one.
+type mergeHeap struct {
+	items []mergeItem
+	keys  Keys
+}
+
+func (h *mergeHeap) Len() int { return len(h.items) }
+func (h *mergeHeap) Less(i, j int) bool {
+	return compareKeys(h.items[i].row, h.items[j].row, h.keys) < 0
+}
+func (h *mergeHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *mergeHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(mergeItem))
+}
+func (h *mergeHeap) Pop() interface{} {
+	old := h.items
+	n := len(old)
+	item := old[n-1]
+	h.items = old[:n-1]
+	return item
+}
+
+func kWayMerge(paths []string, config *Config) (string, error) {
+	readers := make([]*yacr.Reader, len(paths))
+	files := make([]*os.File, len(paths))
+	buffers := make([]Row, len(paths))
+	eofs := make([]bool, len(paths))
+	for i, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return "", err
+		}
+		files[i] = f
+		readers[i] = yacr.NewReader(f, config.Sep, true, false)
+		buffers[i] = make([][]byte, 0, 10)
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	out, err := ioutil.TempFile("", "csvdiff-merge-*.csv")
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	// Quoted for the same reason as flushChunk's writer: the merged file
+	// feeds back into Diff/SortCSV's caller as ordinary CSV, so it must
+	// preserve separators embedded in quoted fields.
+	w := yacr.NewWriter(out, config.Sep, true)
+
+	h := &mergeHeap{keys: config.Keys}
+	heap.Init(h)
+	next := func(i int) error {
+		row, e, err := readRow(readers[i], buffers[i], eofs[i])
+		eofs[i] = e
+		if err != nil {
+			return err
+		}
+		if row != nil {
+			heap.Push(h, mergeItem{row: deepCopy(row), src: i})
+		}
+		return nil
+	}
+	for i := range paths {
+		if err := next(i); err != nil {
+			return "", err
+		}
+	}
+	for h.Len() > 0 {
+		item := heap.Pop(h).(mergeItem)
+		for _, field := range item.row {
+			w.Write(field)
+		}
+		w.EndOfRecord()
+		if err := next(item.src); err != nil {
+			return "", err
+		}
+	}
+	w.Flush()
+	if err := w.Err(); err != nil {
+		return "", err
+	}
+	return out.Name(), nil
+}
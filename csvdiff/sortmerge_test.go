@@ -0,0 +1,233 @@
+/*
+The author disclaims copyright to this source code.
+*/
+
+package csvdiff
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gwenn/yacr"
+	"github.com/spf13/afero"
+)
+
+func TestSortCSV(t *testing.T) {
+	config := NewConfig(',')
+	config.Keys = Keys{0}
+	unsorted := "id,name\n3,carol\n1,alice\n2,bob\n"
+
+	sorted, err := SortCSV(strings.NewReader(unsorted), config)
+	if err != nil {
+		t.Fatalf("SortCSV: %s", err)
+	}
+	defer sorted.Close()
+
+	got, err := ioutil.ReadAll(sorted)
+	if err != nil {
+		t.Fatalf("read sorted output: %s", err)
+	}
+	want := "id,name\n1,alice\n2,bob\n3,carol\n"
+	if string(got) != want {
+		t.Errorf("SortCSV output = %q, want %q", got, want)
+	}
+}
+
+// TestSortCSVPreservesQuotedSeparator reproduces a regression where
+// flushChunk wrote chunk files unquoted, so a key/value legitimately
+// containing the separator inside a quoted field (valid input under
+// Config.Quoted) came back out with an extra field instead of round-tripping.
+func TestSortCSVPreservesQuotedSeparator(t *testing.T) {
+	config := NewConfig(',')
+	config.Keys = Keys{0}
+	unsorted := "id,val\n2,\"b,bb\"\n1,a\n"
+
+	sorted, err := SortCSV(strings.NewReader(unsorted), config)
+	if err != nil {
+		t.Fatalf("SortCSV: %s", err)
+	}
+	defer sorted.Close()
+
+	got, err := ioutil.ReadAll(sorted)
+	if err != nil {
+		t.Fatalf("read sorted output: %s", err)
+	}
+	want := "id,val\n1,a\n2,\"b,bb\"\n"
+	if string(got) != want {
+		t.Errorf("SortCSV output = %q, want %q", got, want)
+	}
+}
+
+// TestKWayMergePreservesQuotedSeparator is TestSortCSVPreservesQuotedSeparator's
+// counterpart for the multi-chunk path: chunk files already contain a
+// quoted field holding the separator, and kWayMerge must read and re-emit
+// it without splitting it into extra fields.
+func TestKWayMergePreservesQuotedSeparator(t *testing.T) {
+	config := NewConfig(',')
+	chunk1, err := ioutil.TempFile("", "csvdiff-test-chunk-*.csv")
+	if err != nil {
+		t.Fatalf("TempFile: %s", err)
+	}
+	defer os.Remove(chunk1.Name())
+	chunk2, err := ioutil.TempFile("", "csvdiff-test-chunk-*.csv")
+	if err != nil {
+		t.Fatalf("TempFile: %s", err)
+	}
+	defer os.Remove(chunk2.Name())
+
+	if _, err := chunk1.WriteString("1,\"b,bb\",x\n"); err != nil {
+		t.Fatalf("write chunk1: %s", err)
+	}
+	chunk1.Close()
+	if _, err := chunk2.WriteString("2,c,y\n"); err != nil {
+		t.Fatalf("write chunk2: %s", err)
+	}
+	chunk2.Close()
+
+	mergedPath, err := kWayMerge([]string{chunk1.Name(), chunk2.Name()}, config)
+	if err != nil {
+		t.Fatalf("kWayMerge: %s", err)
+	}
+	defer os.Remove(mergedPath)
+
+	got, err := ioutil.ReadFile(mergedPath)
+	if err != nil {
+		t.Fatalf("read merged file: %s", err)
+	}
+	want := "1,\"b,bb\",x\n2,c,y\n"
+	if string(got) != want {
+		t.Errorf("merged output = %q, want %q", got, want)
+	}
+
+	// Read back through a quoted reader to confirm the quoted comma
+	// produced exactly 3 fields, not 4 (which an unquoted round-trip
+	// would silently split it into).
+	merged, err := os.Open(mergedPath)
+	if err != nil {
+		t.Fatalf("open merged file: %s", err)
+	}
+	defer merged.Close()
+	reader := yacr.NewReader(merged, ',', true, false)
+	buffer := make(Row, 0, 10)
+	row, _, err := readRow(reader, buffer, false)
+	if err != nil {
+		t.Fatalf("readRow: %s", err)
+	}
+	if len(row) != 3 {
+		t.Errorf("first merged row has %d fields, want 3: %q", len(row), row)
+	}
+}
+
+// TestDiffSortedDetectsOutOfOrder checks that Config.Sorted's stream-merge
+// mode rejects input that isn't actually sorted by key, rather than
+// silently producing a wrong diff.
+func TestDiffSortedDetectsOutOfOrder(t *testing.T) {
+	config := NewConfig(',')
+	config.Fs = afero.NewMemMapFs()
+	config.Keys = Keys{0}
+	config.Sorted = true
+
+	a := "id,name\n2,bob\n1,alice\n"
+	b := "id,name\n1,alice\n2,bob\n"
+	if err := afero.WriteFile(config.Fs, "a.csv", []byte(a), 0644); err != nil {
+		t.Fatalf("write a.csv: %s", err)
+	}
+	if err := afero.WriteFile(config.Fs, "b.csv", []byte(b), 0644); err != nil {
+		t.Fatalf("write b.csv: %s", err)
+	}
+	fileA, err := Open("a.csv", config)
+	if err != nil {
+		t.Fatalf("open a.csv: %s", err)
+	}
+	defer fileA.Close()
+	fileB, err := Open("b.csv", config)
+	if err != nil {
+		t.Fatalf("open b.csv: %s", err)
+	}
+	defer fileB.Close()
+
+	if _, err := NewDiffer(config).Diff(fileA, fileB, nil); err == nil {
+		t.Fatal("expected an out-of-order error, got nil")
+	}
+}
+
+// TestDiffSortedStreamMerge runs the lockstep merge on already-sorted
+// inputs and checks it reports the same Added/Removed/Modified counts the
+// cache-based algorithm would.
+func TestDiffSortedStreamMerge(t *testing.T) {
+	config := NewConfig(',')
+	config.Fs = afero.NewMemMapFs()
+	config.Keys = Keys{0}
+	config.Sorted = true
+
+	a := "id,name\n1,alice\n2,bob\n3,carol\n"
+	b := "id,name\n1,alice\n2,BOB\n4,dave\n"
+	if err := afero.WriteFile(config.Fs, "a.csv", []byte(a), 0644); err != nil {
+		t.Fatalf("write a.csv: %s", err)
+	}
+	if err := afero.WriteFile(config.Fs, "b.csv", []byte(b), 0644); err != nil {
+		t.Fatalf("write b.csv: %s", err)
+	}
+	fileA, err := Open("a.csv", config)
+	if err != nil {
+		t.Fatalf("open a.csv: %s", err)
+	}
+	defer fileA.Close()
+	fileB, err := Open("b.csv", config)
+	if err != nil {
+		t.Fatalf("open b.csv: %s", err)
+	}
+	defer fileB.Close()
+
+	stats, err := NewDiffer(config).Diff(fileA, fileB, nil)
+	if err != nil {
+		t.Fatalf("Diff: %s", err)
+	}
+	if stats.Added != 1 || stats.Removed != 1 || stats.Modified != 1 {
+		t.Errorf("stats = %+v, want Added=1 Removed=1 Modified=1", stats)
+	}
+}
+
+// TestKWayMerge exercises the k-way merge of several already-sorted chunk
+// files directly, the step SortCSV relies on to reassemble chunks once an
+// input is too large to sort in memory in one pass.
+func TestKWayMerge(t *testing.T) {
+	config := NewConfig(',')
+	config.Keys = Keys{0}
+	chunk1, err := ioutil.TempFile("", "csvdiff-test-chunk-*.csv")
+	if err != nil {
+		t.Fatalf("TempFile: %s", err)
+	}
+	defer os.Remove(chunk1.Name())
+	chunk2, err := ioutil.TempFile("", "csvdiff-test-chunk-*.csv")
+	if err != nil {
+		t.Fatalf("TempFile: %s", err)
+	}
+	defer os.Remove(chunk2.Name())
+
+	if _, err := chunk1.WriteString("1,alice\n3,carol\n"); err != nil {
+		t.Fatalf("write chunk1: %s", err)
+	}
+	chunk1.Close()
+	if _, err := chunk2.WriteString("2,bob\n4,dave\n"); err != nil {
+		t.Fatalf("write chunk2: %s", err)
+	}
+	chunk2.Close()
+
+	mergedPath, err := kWayMerge([]string{chunk1.Name(), chunk2.Name()}, config)
+	if err != nil {
+		t.Fatalf("kWayMerge: %s", err)
+	}
+	defer os.Remove(mergedPath)
+
+	got, err := ioutil.ReadFile(mergedPath)
+	if err != nil {
+		t.Fatalf("read merged file: %s", err)
+	}
+	want := "1,alice\n2,bob\n3,carol\n4,dave\n"
+	if string(got) != want {
+		t.Errorf("merged output = %q, want %q", got, want)
+	}
+}
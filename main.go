@@ -0,0 +1,169 @@
+/*
+The author disclaims copyright to this source code.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/riomhaire/csvdiff/csvdiff"
+)
+
+func atouis(s string) (values []uint64) {
+	rawValues := strings.Split(s, ",")
+	values = make([]uint64, len(rawValues))
+	for i, v := range rawValues {
+		f, err := strconv.ParseUint(v, 10, 0)
+		if err != nil {
+			flag.Usage()
+			log.Fatalf("Invalid field index (%v)\n", v)
+		}
+		values[i] = f - 1
+	}
+	return
+}
+
+// TODO Add an option to ignore appended/new field(s).
+func parseArgs() (*csvdiff.Config, bool) {
+	var n *bool = flag.Bool("n", false, "No header")
+	var f *int = flag.Int("f", 0, "Format used to display delta (0: ansi bold, 1: piped, 2: newline, 3: binary patch, see csvpatch)")
+	var q *bool = flag.Bool("q", true, "Quoted field mode")
+	var sep *string = flag.String("s", ",", "Set the field separator")
+	var k *string = flag.String("k", "", "Set the key indexes (starts at 1)")
+	var i *string = flag.String("i", "", "Set the ignored field indexes (starts at 1)")
+	var c *bool = flag.Bool("c", false, "Output common/same lines")
+	var h *string = flag.String("H", "blake3", "Hash used to bucket keys (blake3, xxh3, fnv64a)")
+	var sorted *bool = flag.Bool("sorted", false, "Assume FILEA and FILEB are already sorted by -k and stream-merge them instead of caching")
+	var doSort *bool = flag.Bool("sort", false, "Sort FILEA and FILEB by -k (bounded memory) before diffing them in --sorted mode")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-n] [-q] [-c] [-s=C] [-i=N,...] [-H=blake3|xxh3|fnv64a] [-sorted|-sort] -k=N[,...] FILEA FILEB\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 2 {
+		flag.Usage()
+		log.Fatalf("Missing FILE argument(s)\n")
+	} else if flag.NArg() > 2 {
+		flag.Usage()
+		log.Fatalf("Too many FILE arguments\n")
+	}
+	if *sep == "\\t" {
+		*sep = "\t"
+	} else if len(*sep) > 1 {
+		flag.Usage()
+		log.Fatalf("Separator must be only one character long\n")
+	}
+	guess := true
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "s" {
+			guess = false
+		}
+	})
+
+	var keys csvdiff.Keys
+	if len(*k) > 0 {
+		keys = atouis(*k)
+	} else {
+		flag.Usage()
+		log.Fatalf("Missing Key argument(s)\n")
+	}
+	var ignoredFields = make(map[int]bool)
+	if len(*i) > 0 {
+		for _, index := range atouis(*i) {
+			ignoredFields[int(index)] = true
+		}
+	}
+	if *f == 0 {
+		fi, e := os.Stdout.Stat()
+		// Disable bold output when stdout is redirected to a file
+		if e == nil && (fi.Mode()&os.ModeType == 0) {
+			*f = 1
+		}
+	}
+	config := csvdiff.NewConfig((*sep)[0])
+	config.NoHeader = *n
+	config.Guess = guess
+	config.Quoted = *q
+	config.Keys = keys
+	config.IgnoredFields = ignoredFields
+	config.Format = *f
+	config.Common = *c
+	switch *h {
+	case "blake3":
+		config.Hash = csvdiff.BLAKE3
+	case "xxh3":
+		config.Hash = csvdiff.XXH3
+	case "fnv64a":
+		config.Hash = csvdiff.FNV64a
+	default:
+		flag.Usage()
+		log.Fatalf("Unknown hash '%s'\n", *h)
+	}
+	config.Sorted = *sorted || *doSort
+	config.NameA = flag.Arg(0)
+	config.NameB = flag.Arg(1)
+	return config, *doSort
+}
+
+func main() {
+	config, doSort := parseArgs()
+	config.OnWarning = func(msg string) {
+		fmt.Fprintf(os.Stderr, "%s\n", msg)
+	}
+
+	inA, err := csvdiff.Open(flag.Arg(0), config)
+	if err != nil {
+		log.Fatalf("%s\n", err)
+	}
+	defer inA.Close()
+	inB, err := csvdiff.Open(flag.Arg(1), config)
+	if err != nil {
+		log.Fatalf("%s\n", err)
+	}
+	defer inB.Close()
+
+	if doSort {
+		sortedA, err := csvdiff.SortCSV(inA, config)
+		if err != nil {
+			log.Fatalf("Error while sorting '%s': %s\n", flag.Arg(0), err)
+		}
+		defer sortedA.Close()
+		sortedB, err := csvdiff.SortCSV(inB, config)
+		if err != nil {
+			log.Fatalf("Error while sorting '%s': %s\n", flag.Arg(1), err)
+		}
+		defer sortedB.Close()
+		inA, inB = sortedA, sortedB
+	}
+
+	differ := csvdiff.NewDiffer(config)
+	stats, err := differ.Diff(inA, inB, os.Stdout)
+	if err != nil {
+		log.Fatalf("%s\n", err)
+	}
+
+	if stats.Added > 0 || stats.Removed > 0 || stats.Modified > 0 {
+		fmt.Fprintf(os.Stderr, "Total: %d, Removed: %d, Added: %d, Modified: %d\n",
+			stats.Total, stats.Removed, stats.Added, stats.Modified)
+		if stats.Modified > 0 {
+			fmt.Fprintf(os.Stderr, "Modified fields: ")
+			modified := []string{}
+			for i, b := range stats.ModifiedFields {
+				if b {
+					if stats.Headers != nil {
+						modified = append(modified, fmt.Sprintf("%s (%d)", stats.Headers[i], i+1))
+					} else {
+						modified = append(modified, fmt.Sprintf("%d", i+1))
+					}
+				}
+			}
+			fmt.Fprintf(os.Stderr, "%s\n", strings.Join(modified, ", "))
+		}
+		os.Exit(1)
+	}
+}